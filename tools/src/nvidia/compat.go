@@ -0,0 +1,148 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompatPolicy controls whether the CUDA forward-compatibility package
+// (/usr/local/cuda/compat) is mounted into the container.
+type CompatPolicy int
+
+const (
+	// CompatDisabled never mounts the compat libcuda.so, regardless of its
+	// version relative to the host driver.
+	CompatDisabled CompatPolicy = iota
+	// CompatIfNewer mounts the compat libcuda.so only when its driver
+	// version is strictly greater than the host driver's.
+	CompatIfNewer
+	// CompatAlways mounts the compat libcuda.so whenever one is found.
+	CompatAlways
+)
+
+const (
+	compatDir        = "compat"
+	compatRoot       = "/usr/local/cuda/compat"
+	compatLdConfName = "nvidia-compat.conf"
+)
+
+var compatSonameRegexp = regexp.MustCompile(`libcuda\.so\.([\w.]+)$`)
+
+// CompatLibrary describes a CUDA forward-compatibility libcuda.so found on
+// the host.
+type CompatLibrary struct {
+	Path    string // host path of the compat libcuda.so.N
+	Version string // driver version embedded in its SONAME
+}
+
+// discoverCompatLibrary looks under compatRoot for a libcuda.so whose
+// embedded driver version satisfies policy relative to hostVersion,
+// returning a nil library if none qualifies.
+func discoverCompatLibrary(policy CompatPolicy, hostVersion string) (*CompatLibrary, error) {
+	matches, err := filepath.Glob(path.Join(compatRoot, "libcuda.so.*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range matches {
+		obj, err := elf.Open(m)
+		if err != nil {
+			continue
+		}
+		soname, err := obj.DynString(elf.DT_SONAME)
+		obj.Close()
+		if err != nil || len(soname) == 0 {
+			continue
+		}
+
+		sm := compatSonameRegexp.FindStringSubmatch(soname[0])
+		if sm == nil {
+			continue
+		}
+		version := sm[1]
+
+		if policy == CompatIfNewer && !versionGreater(version, hostVersion) {
+			continue
+		}
+		return &CompatLibrary{Path: m, Version: version}, nil
+	}
+	return nil, nil
+}
+
+// createCompatAt clones the resolved compat library into its own
+// subdirectory of root and drops an ld.so.conf.d fragment pointing at it, so
+// the dynamic loader prefers it over the regular driver libraries.
+func (v *Volume) createCompatAt(root string, s FileCloneStrategy) error {
+	dir := path.Join(root, compatDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	obj, err := elf.Open(v.compat.Path)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	soname, err := obj.DynString(elf.DT_SONAME)
+	if err != nil {
+		return err
+	}
+	if len(soname) == 0 {
+		return fmt.Errorf("compat: %s has no SONAME", v.compat.Path)
+	}
+
+	l := path.Join(dir, path.Base(v.compat.Path))
+	if err := s.Clone(v.compat.Path, l); err != nil {
+		return err
+	}
+
+	target := path.Join(v.Mountpoint, compatDir, path.Base(v.compat.Path))
+	link := path.Join(dir, soname[0])
+	if err := os.Symlink(target, link); err != nil && !os.IsExist(err.(*os.LinkError).Err) {
+		return err
+	}
+
+	conf := path.Join(v.Mountpoint, compatDir) + "\n"
+	return ioutil.WriteFile(path.Join(dir, compatLdConfName), []byte(conf), 0644)
+}
+
+// CompatEnv returns the environment variables that make the dynamic loader
+// prefer the forward-compatibility libcuda.so, or nil if none was mounted.
+func (v *Volume) CompatEnv() []string {
+	if v.compat == nil {
+		return nil
+	}
+	return []string{
+		"LD_LIBRARY_PATH=" + path.Join(v.Mountpoint, compatDir),
+	}
+}
+
+// versionGreater reports whether a is a strictly greater dot-separated
+// version than b, comparing each numeric component in turn.
+func versionGreater(a, b string) bool {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var av, bv int
+		if i < len(as) {
+			av, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bv, _ = strconv.Atoi(bs[i])
+		}
+		if av != bv {
+			return av > bv
+		}
+	}
+	return false
+}
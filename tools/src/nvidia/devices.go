@@ -0,0 +1,274 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	pciDevicesRoot  = "/sys/bus/pci/devices"
+	nvidiaVendorID  = "0x10de"
+	nvidiaGPUInfo   = "/proc/driver/nvidia/gpus"
+	nvidiaDevPrefix = "/dev/nvidia"
+)
+
+// ControlDevices are the shared NVIDIA control nodes to expose alongside
+// any per-GPU device found by LookupDevices.
+var ControlDevices = []string{
+	"/dev/nvidiactl",
+	"/dev/nvidia-uvm",
+	"/dev/nvidia-uvm-tools",
+	"/dev/nvidia-modeset",
+}
+
+// DRMNode is a single /dev/dri/{card,renderD}N node.
+type DRMNode struct {
+	Path  string
+	Major uint32
+	Minor uint32
+}
+
+// DeviceInfo groups the device nodes for one physical GPU: its /dev/nvidiaN
+// control node plus, when present, the DRM card/render nodes sharing its
+// PCI address.
+type DeviceInfo struct {
+	PCIAddress string
+	Path       string // /dev/nvidiaN
+	Major      uint32
+	Minor      uint32
+	DRMCard    *DRMNode // /dev/dri/cardN, if any
+	DRMRender  *DRMNode // /dev/dri/renderDN, if any
+}
+
+// LookupDevices walks /sys/bus/pci/devices, selects the NVIDIA GPUs (vendor
+// 0x10de) and resolves each one's /dev/nvidiaN plus any DRM nodes under its
+// PCI address.
+func LookupDevices() ([]DeviceInfo, error) {
+	pciDirs, err := ioutil.ReadDir(pciDevicesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []DeviceInfo
+	var smi nvidiaSmiGPUs
+	for _, d := range pciDirs {
+		addr := d.Name()
+
+		vendor, err := readSysfsString(path.Join(pciDevicesRoot, addr, "vendor"))
+		if err != nil {
+			return nil, err
+		}
+		if vendor != nvidiaVendorID {
+			continue
+		}
+
+		dev, err := lookupGPUDevice(addr, &smi)
+		if err != nil {
+			return nil, err
+		}
+		if dev == nil {
+			continue
+		}
+		devices = append(devices, *dev)
+	}
+	return devices, nil
+}
+
+// lookupGPUDevice resolves the /dev/nvidiaN and DRM nodes for the GPU at
+// addr, returning a nil DeviceInfo if the driver doesn't know this PCI
+// address (e.g. it's bound to vfio-pci instead). smi is shared across a
+// LookupDevices run so the nvidia-smi fallback runs at most once.
+func lookupGPUDevice(addr string, smi *nvidiaSmiGPUs) (*DeviceInfo, error) {
+	minor, err := gpuMinor(addr, smi)
+	if err != nil {
+		return nil, err
+	}
+	if minor < 0 {
+		return nil, nil
+	}
+
+	devPath := fmt.Sprintf("%s%d", nvidiaDevPrefix, minor)
+	major, min, err := deviceMajorMinor(devPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dev := &DeviceInfo{
+		PCIAddress: addr,
+		Path:       devPath,
+		Major:      major,
+		Minor:      min,
+	}
+
+	entries, err := ioutil.ReadDir(path.Join(pciDevicesRoot, addr, "drm"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, "card"):
+			node, err := newDRMNode(name)
+			if err != nil {
+				return nil, err
+			}
+			dev.DRMCard = node
+		case strings.HasPrefix(name, "renderD"):
+			node, err := newDRMNode(name)
+			if err != nil {
+				return nil, err
+			}
+			dev.DRMRender = node
+		}
+	}
+	return dev, nil
+}
+
+// gpuMinor returns the /dev/nvidiaN minor number for addr, or -1 if unknown.
+// It prefers /proc/driver/nvidia/gpus, falling back to smi where that's
+// masked (e.g. unprivileged containers).
+func gpuMinor(addr string, smi *nvidiaSmiGPUs) (int, error) {
+	minor, err := gpuMinorFromProc(addr)
+	if err != nil || minor >= 0 {
+		return minor, err
+	}
+	return smi.minor(addr)
+}
+
+func gpuMinorFromProc(addr string) (int, error) {
+	f, err := os.Open(path.Join(nvidiaGPUInfo, addr, "information"))
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	re := regexp.MustCompile(`Device Minor:\s+(\d+)`)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m := re.FindStringSubmatch(s.Text()); m != nil {
+			return strconv.Atoi(m[1])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return -1, err
+	}
+	return -1, nil
+}
+
+// nvidiaSmiLog is the handful of `nvidia-smi -q -x` fields needed to map a
+// PCI address to its /dev/nvidiaN minor number.
+type nvidiaSmiLog struct {
+	GPUs []struct {
+		ID          string `xml:"id,attr"`
+		MinorNumber string `xml:"minor_number"`
+	} `xml:"gpu"`
+}
+
+// nvidiaSmiGPUs is the `nvidia-smi -q -x` fallback for gpuMinor, caching the
+// result so resolving N GPUs runs the subprocess at most once.
+type nvidiaSmiGPUs struct {
+	once   sync.Once
+	minors map[string]int // normalizePCIAddr(pci address) -> minor number
+	err    error
+}
+
+func (c *nvidiaSmiGPUs) minor(addr string) (int, error) {
+	c.once.Do(func() {
+		c.minors, c.err = queryNvidiaSmiMinors()
+	})
+	if c.err != nil {
+		return -1, c.err
+	}
+	if m, ok := c.minors[normalizePCIAddr(addr)]; ok {
+		return m, nil
+	}
+	return -1, nil
+}
+
+func queryNvidiaSmiMinors() (map[string]int, error) {
+	out, err := exec.Command("nvidia-smi", "-q", "-x").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var log nvidiaSmiLog
+	if err := xml.Unmarshal(out, &log); err != nil {
+		return nil, err
+	}
+
+	minors := make(map[string]int, len(log.GPUs))
+	for _, gpu := range log.GPUs {
+		minor, err := strconv.Atoi(strings.TrimSpace(gpu.MinorNumber))
+		if err != nil {
+			return nil, err
+		}
+		minors[normalizePCIAddr(gpu.ID)] = minor
+	}
+	return minors, nil
+}
+
+// normalizePCIAddr pads the domain so sysfs and nvidia-smi addresses for the
+// same device compare equal (e.g. "0000:01:00.0" vs "00000000:01:00.0").
+func normalizePCIAddr(addr string) string {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 {
+		return strings.ToLower(addr)
+	}
+	domain, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return strings.ToLower(addr)
+	}
+	return fmt.Sprintf("%04x:%s", domain, strings.ToLower(parts[1]))
+}
+
+func newDRMNode(name string) (*DRMNode, error) {
+	p := path.Join("/dev/dri", name)
+	major, minor, err := deviceMajorMinor(p)
+	if err != nil {
+		return nil, err
+	}
+	return &DRMNode{Path: p, Major: major, Minor: minor}, nil
+}
+
+// DeviceMajorMinor exposes the major/minor of an arbitrary device node (e.g.
+// one of ControlDevices) for building a cgroup devices.allow list.
+func DeviceMajorMinor(p string) (major, minor uint32, err error) {
+	return deviceMajorMinor(p)
+}
+
+func deviceMajorMinor(p string) (major, minor uint32, err error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return 0, 0, err
+	}
+	dev := uint64(st.Rdev)
+	// Same encoding as glibc's gnu_dev_major/gnu_dev_minor.
+	major = uint32((dev & 0x00000000000fff00) >> 8)
+	major |= uint32((dev & 0xfffff00000000000) >> 32)
+	minor = uint32(dev & 0x00000000000000ff)
+	minor |= uint32((dev & 0x00000ffffff00000) >> 12)
+	return major, minor, nil
+}
+
+func readSysfsString(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
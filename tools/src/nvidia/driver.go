@@ -0,0 +1,149 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"ldcache"
+	"nvml"
+)
+
+// DriverVersionStrategy is one way of determining the host NVIDIA display
+// driver version.
+type DriverVersionStrategy interface {
+	// Name identifies the strategy, so callers (e.g. nvidia-docker-plugin)
+	// can surface which one actually succeeded.
+	Name() string
+	DriverVersion() (string, error)
+}
+
+// DriverVersionResolver walks an ordered list of strategies and returns the
+// version reported by the first one that succeeds.
+type DriverVersionResolver struct {
+	Strategies []DriverVersionStrategy
+}
+
+// NewDriverVersionResolver returns a resolver with the default strategy
+// chain: NVML, /proc/driver/nvidia, the libcuda.so SONAME, nvidia-smi and
+// finally, as a last resort, the given user-supplied override.
+func NewDriverVersionResolver(override string) *DriverVersionResolver {
+	return &DriverVersionResolver{
+		Strategies: []DriverVersionStrategy{
+			nvmlStrategy{},
+			procStrategy{},
+			ldcacheStrategy{},
+			nvidiaSmiStrategy{},
+			overrideStrategy{override},
+		},
+	}
+}
+
+// Resolve returns the version and name of the first strategy that succeeds.
+func (r *DriverVersionResolver) Resolve() (version string, strategy string, err error) {
+	var errs []string
+	for _, s := range r.Strategies {
+		v, e := s.DriverVersion()
+		if e == nil && v != "" {
+			return v, s.Name(), nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", s.Name(), e))
+	}
+	return "", "", fmt.Errorf("could not determine the NVIDIA driver version: %s", strings.Join(errs, "; "))
+}
+
+// GetDriverVersion determines the host NVIDIA driver version using the
+// default strategy chain, falling back to NVIDIA_DRIVER_VERSION if set.
+func GetDriverVersion() (string, error) {
+	v, _, err := NewDriverVersionResolver(os.Getenv("NVIDIA_DRIVER_VERSION")).Resolve()
+	return v, err
+}
+
+type nvmlStrategy struct{}
+
+func (nvmlStrategy) Name() string { return "nvml" }
+
+func (nvmlStrategy) DriverVersion() (string, error) {
+	return nvml.DriverVersion()
+}
+
+type procStrategy struct{}
+
+func (procStrategy) Name() string { return "proc" }
+
+func (procStrategy) DriverVersion() (string, error) {
+	f, err := os.Open("/proc/driver/nvidia/version")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	re := regexp.MustCompile(`Kernel Module\s+([\w.]+)`)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m := re.FindStringSubmatch(s.Text()); m != nil {
+			return m[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("proc: version string not found in /proc/driver/nvidia/version")
+}
+
+type ldcacheStrategy struct{}
+
+func (ldcacheStrategy) Name() string { return "ldcache" }
+
+func (ldcacheStrategy) DriverVersion() (string, error) {
+	cache, err := ldcache.Open()
+	if err != nil {
+		return "", err
+	}
+	defer cache.Close()
+
+	re := regexp.MustCompile(`libcuda\.so\.([\w.]+)$`)
+	_, libs64 := cache.Lookup("libcuda.so")
+	for _, l := range libs64 {
+		if m := re.FindStringSubmatch(l); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("ldcache: libcuda.so SONAME has no version suffix")
+}
+
+type nvidiaSmiStrategy struct{}
+
+func (nvidiaSmiStrategy) Name() string { return "nvidia-smi" }
+
+func (nvidiaSmiStrategy) DriverVersion() (string, error) {
+	out, err := exec.Command("nvidia-smi", "--query-gpu=driver_version", "--format=csv,noheader").Output()
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if v == "" {
+		return "", fmt.Errorf("nvidia-smi: empty output")
+	}
+	return v, nil
+}
+
+// overrideStrategy always returns the version it was constructed with,
+// failing only if none was supplied.
+type overrideStrategy struct {
+	version string
+}
+
+func (overrideStrategy) Name() string { return "override" }
+
+func (s overrideStrategy) DriverVersion() (string, error) {
+	if s.version == "" {
+		return "", fmt.Errorf("override: no version configured")
+	}
+	return s.version, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// configRoot is where the "configs" component's JSON manifests live on the host.
+const configRoot = "/usr/share"
+
+// icdManifest is permissive enough to pull library_path out of a Vulkan
+// ICD, Vulkan implicit layer or GLVND/EGL vendor manifest alike.
+type icdManifest struct {
+	ICD struct {
+		LibraryPath string `json:"library_path"`
+	} `json:"ICD"`
+	Layer struct {
+		LibraryPath string `json:"library_path"`
+	} `json:"layer"`
+	LibraryPath string `json:"library_path"`
+}
+
+func (m icdManifest) library() string {
+	switch {
+	case m.ICD.LibraryPath != "":
+		return m.ICD.LibraryPath
+	case m.Layer.LibraryPath != "":
+		return m.Layer.LibraryPath
+	default:
+		return m.LibraryPath
+	}
+}
+
+// configGroup is every manifest lookupConfigs found under one "configs"
+// component entry, keyed by its subdir relative to configRoot (e.g.
+// "vulkan/icd.d") so each loader's manifests can be mounted under their own
+// destination directory instead of a single flat one.
+type configGroup struct {
+	subdir string
+	files  []string
+}
+
+// lookupConfigs resolves a list of "configs" component entries to absolute
+// paths under configRoot, grouped by subdir. An entry naming a single file
+// (e.g. "vulkan/icd.d/nvidia_icd.json") is matched as-is; an entry naming a
+// directory (e.g. "vulkan/implicit_layer.d") is expanded to every manifest
+// it contains.
+func lookupConfigs(names ...string) ([]configGroup, error) {
+	var groups []configGroup
+
+	for _, n := range names {
+		p := path.Join(configRoot, n)
+
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			groups = append(groups, configGroup{subdir: path.Dir(n), files: []string{p}})
+			continue
+		}
+
+		matches, err := filepath.Glob(path.Join(p, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		groups = append(groups, configGroup{subdir: n, files: matches})
+	}
+	return groups, nil
+}
+
+// resolveConfigLibraries makes sure every library a manifest references is
+// present in the volume, pulling it in through lookup if it wasn't already
+// mounted by the "libraries" component.
+func (v *Volume) resolveConfigLibraries(lookup func(names ...string) (libs32, libs64 []string), manifests []string) error {
+	have := make(map[string]bool)
+	for _, d := range v.dirs {
+		if d.name != lib32Dir && d.name != lib64Dir {
+			continue
+		}
+		for _, f := range d.files {
+			have[path.Base(f)] = true
+		}
+	}
+
+	var missing []string
+	for _, m := range manifests {
+		f, err := os.Open(m)
+		if err != nil {
+			return err
+		}
+		var icd icdManifest
+		err = json.NewDecoder(f).Decode(&icd)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		lib := icd.library()
+		if lib == "" || have[path.Base(lib)] {
+			continue
+		}
+		missing = append(missing, path.Base(lib))
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	libs32, libs64 := lookup(missing...)
+	for i, d := range v.dirs {
+		switch d.name {
+		case lib32Dir:
+			v.dirs[i].files = append(v.dirs[i].files, libs32...)
+		case lib64Dir:
+			v.dirs[i].files = append(v.dirs[i].files, libs64...)
+		}
+	}
+	return nil
+}
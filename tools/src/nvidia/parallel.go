@@ -0,0 +1,156 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"debug/elf"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// maxCreateWorkers caps the Volume.Create worker pool regardless of NumCPU.
+const maxCreateWorkers = 8
+
+// createJob is one file to inspect, blacklist-check and clone into dir.
+// mountDir is its container-side directory, used for the SONAME symlink
+// target. raw marks a job whose file isn't an ELF object (see volumeDir.raw).
+type createJob struct {
+	dir      string
+	mountDir string
+	file     string
+	raw      bool
+}
+
+// createFiles runs createFile for every job across a bounded worker pool,
+// aborting on the first error.
+func createFiles(jobs []createJob, s FileCloneStrategy) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	workers := runtime.NumCPU()
+	if workers > maxCreateWorkers {
+		workers = maxCreateWorkers
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	jobCh := make(chan createJob)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	var abort sync.Once
+
+	// Symlink creation for a given directory must be serialized: several
+	// jobs in the same directory can race to create (or skip over an
+	// already-existing) SONAME symlink.
+	var locksMu sync.Mutex
+	locks := make(map[string]*sync.Mutex)
+	lockFor := func(dir string) *sync.Mutex {
+		locksMu.Lock()
+		defer locksMu.Unlock()
+		l, ok := locks[dir]
+		if !ok {
+			l = new(sync.Mutex)
+			locks[dir] = l
+		}
+		return l
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				if err := createFile(job, s, lockFor(job.dir)); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					abort.Do(func() { close(done) })
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobCh)
+		for _, j := range jobs {
+			select {
+			case jobCh <- j:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// createFile opens, blacklist-checks and clones a single file, creating its
+// SONAME symlink under lock so concurrent workers sharing dir don't race.
+// A raw job (e.g. a "configs" manifest) is just cloned as-is.
+func createFile(job createJob, s FileCloneStrategy, lock *sync.Mutex) error {
+	if job.raw {
+		return s.Clone(job.file, path.Join(job.dir, path.Base(job.file)))
+	}
+
+	obj, err := elf.Open(job.file)
+	if err != nil {
+		return err
+	}
+
+	ok, err := blacklisted(job.file, obj)
+	if err != nil {
+		obj.Close()
+		return err
+	}
+	if ok {
+		obj.Close()
+		return nil
+	}
+
+	l := path.Join(job.dir, path.Base(job.file))
+	if err := s.Clone(job.file, l); err != nil {
+		obj.Close()
+		return err
+	}
+
+	soname, err := obj.DynString(elf.DT_SONAME)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+	if len(soname) == 0 {
+		return nil
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	target := path.Join(job.mountDir, path.Base(job.file))
+	l = path.Join(job.dir, soname[0])
+	if err := os.Symlink(target, l); err != nil && !os.IsExist(err.(*os.LinkError).Err) {
+		return err
+	}
+	// XXX GLVND requires this symlink for indirect GLX support
+	// It won't be needed once we have an indirect GLX vendor neutral library.
+	if strings.HasPrefix(soname[0], "libGLX_nvidia") {
+		l = strings.Replace(l, "GLX_nvidia", "GLX_indirect", 1)
+		if err := os.Symlink(target, l); err != nil && !os.IsExist(err.(*os.LinkError).Err) {
+			return err
+		}
+	}
+	return nil
+}
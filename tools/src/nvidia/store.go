@@ -0,0 +1,202 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// casDir is the content-addressed store backing dedupe's cross-version
+// hardlinks, sibling to the versioned directories under a Volume's Path.
+const casDir = ".cas"
+
+// dedupe hardlinks every regular file newly written under root to a
+// per-content blob under <Path>/.cas, so later versions sharing content
+// don't cost extra disk.
+func (v *Volume) dedupe(root string) error {
+	casRoot := path.Join(v.Path, casDir)
+	if err := os.MkdirAll(casRoot, 0755); err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		sum, err := sha256File(p)
+		if err != nil {
+			return err
+		}
+		blob := path.Join(casRoot, sum)
+
+		if err := os.Link(blob, p+".new"); err == nil {
+			// A blob with this content already exists: swap it in for the
+			// file we just cloned.
+			if err := os.Rename(p+".new", p); err != nil {
+				return err
+			}
+			return nil
+		} else if !os.IsNotExist(err) && !os.IsExist(err) {
+			return err
+		}
+		os.Remove(p + ".new")
+
+		// First time we've seen this content: seed the store with it.
+		if err := os.Link(p, blob); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GC removes every installed version beyond the keep most-recent ones,
+// skipping any still bind-mounted into a running container, then sweeps
+// the content-addressed store for now-unreferenced blobs.
+func (v *Volume) GC(keep int) error {
+	if keep < 0 {
+		keep = 0
+	}
+
+	entries, err := ioutil.ReadDir(v.Path)
+	if err != nil {
+		return err
+	}
+
+	var versions []string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		versions = append(versions, e.Name())
+	}
+	if len(versions) <= keep {
+		return nil
+	}
+
+	// Newest first, so versions[keep:] is exactly what's eligible for removal.
+	sort.Slice(versions, func(i, j int) bool { return versionGreater(versions[i], versions[j]) })
+
+	mounted, err := mountedVersions(v.Path, versions)
+	if err != nil {
+		return err
+	}
+
+	for _, vv := range versions[keep:] {
+		if mounted[vv] {
+			continue
+		}
+		if err := v.Remove(vv); err != nil {
+			return err
+		}
+	}
+	return v.gcStore()
+}
+
+// mountedVersions returns the subset of versions bind-mounted into a
+// running process, per /proc/*/mountinfo.
+func mountedVersions(root string, versions []string) (map[string]bool, error) {
+	known := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		known[v] = true
+	}
+
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	pid := regexp.MustCompile(`^\d+$`)
+	prefix := root + "/"
+	mounted := make(map[string]bool)
+
+	for _, p := range procs {
+		if !pid.MatchString(p.Name()) {
+			continue
+		}
+
+		f, err := os.Open(path.Join("/proc", p.Name(), "mountinfo"))
+		if err != nil {
+			// The process may have exited since ReadDir, or we may lack
+			// permission to read it; either way it mounts nothing we can act on.
+			continue
+		}
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			// mountinfo(5): "... major:minor root mount-point ...", root being
+			// the bind-mounted source path within its filesystem.
+			fields := strings.Fields(s.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			src := fields[3]
+			if !strings.HasPrefix(src, prefix) {
+				continue
+			}
+			version := strings.SplitN(strings.TrimPrefix(src, prefix), "/", 2)[0]
+			if known[version] {
+				mounted[version] = true
+			}
+		}
+		f.Close()
+	}
+	return mounted, nil
+}
+
+// gcStore removes any CAS blob no surviving version still links to.
+func (v *Volume) gcStore() error {
+	casRoot := path.Join(v.Path, casDir)
+	entries, err := ioutil.ReadDir(casRoot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		p := path.Join(casRoot, e.Name())
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		// Nlink == 1 means the blob itself is the only remaining reference.
+		if st.Nlink <= 1 {
+			os.Remove(p)
+		}
+	}
+	return nil
+}
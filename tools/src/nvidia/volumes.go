@@ -8,6 +8,7 @@ import (
 	"debug/elf"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
@@ -19,9 +20,10 @@ import (
 )
 
 const (
-	binDir   = "bin"
-	lib32Dir = "lib"
-	lib64Dir = "lib64"
+	binDir    = "bin"
+	lib32Dir  = "lib"
+	lib64Dir  = "lib64"
+	configDir = "etc"
 )
 
 type components map[string][]string
@@ -29,12 +31,17 @@ type components map[string][]string
 type volumeDir struct {
 	name  string
 	files []string
+	// raw marks a directory whose files are plain data, not ELF objects
+	// (e.g. the "configs" component's JSON manifests): createFile clones
+	// them as-is instead of running the blacklist/SONAME-symlink logic.
+	raw bool
 }
 
 type VolumeInfo struct {
 	Name       string
 	Mountpoint string
 	Components components
+	Compat     CompatPolicy
 }
 
 type Volume struct {
@@ -43,6 +50,7 @@ type Volume struct {
 	Path    string
 	Version string
 	dirs    []volumeDir
+	compat  *CompatLibrary
 }
 
 type VolumeMap map[string]*Volume
@@ -130,15 +138,17 @@ var Volumes = []VolumeInfo{
 				"libnvidia-fatbinaryloader.so", // fatbin loader (used by libcuda)
 				"libnvidia-opencl.so",          // NVIDIA OpenCL ICD
 				"libnvidia-compiler.so",        // NVVM-PTX compiler for OpenCL (used by libnvidia-opencl)
+				"libnvidia-nvvm.so",            // NVVM compiler library (used by libnvidia-compiler)
 				//"libOpenCL.so",               // OpenCL ICD loader
 
 				// ------ Video ------
 
-				"libvdpau_nvidia.so",  // NVIDIA VDPAU ICD
-				"libnvidia-encode.so", // Video encoder
-				"libnvcuvid.so",       // Video decoder
-				"libnvidia-fbc.so",    // Framebuffer capture
-				"libnvidia-ifr.so",    // OpenGL framebuffer capture
+				"libvdpau_nvidia.so",       // NVIDIA VDPAU ICD
+				"libnvidia-encode.so",      // Video encoder
+				"libnvcuvid.so",            // Video decoder
+				"libnvidia-fbc.so",         // Framebuffer capture
+				"libnvidia-ifr.so",         // OpenGL framebuffer capture
+				"libnvidia-opticalflow.so", // Optical flow estimation
 
 				// ----- Graphic -----
 
@@ -162,8 +172,37 @@ var Volumes = []VolumeInfo{
 				"libnvidia-glcore.so",    // OpenGL core (used by libGL or libGLX_nvidia)
 				"libnvidia-tls.so",       // Thread local storage (used by libGL or libGLX_nvidia)
 				"libnvidia-glsi.so",      // OpenGL system interaction (used by libEGL_nvidia)
+				"libnvidia-allocator.so", // Memory allocator used by the EGL/Vulkan platform libraries
+				"libglxserver_nvidia.so", // GLX extension module for X server (used by nvidia-xconfig setups)
+
+				// ------ EGL platforms ------
+
+				"libnvidia-egl-gbm.so",     // EGL external platform for GBM (headless/offscreen rendering)
+				"libnvidia-egl-wayland.so", // EGL external platform for Wayland
+
+				// ----- Vulkan -----
+
+				"libnvidia-vulkan-producer.so", // Vulkan producer (used by the EGLStream Vulkan ICD)
+				"libnvidia-glvkspirv.so",       // SPIR-V compiler shared by OpenGL and Vulkan
+
+				// ----- RTX / Optix -----
+
+				"libnvidia-rtcore.so", // Hardware ray tracing core (used by libcuda and the Vulkan ICD)
+				"libnvoptix.so",       // OptiX ray tracing engine
+				"libnvidia-ngx.so",    // NGX (DLSS) library
+			},
+			"configs": {
+				"vulkan/icd.d/nvidia_icd.json",      // Vulkan ICD manifest
+				"vulkan/implicit_layer.d",           // Vulkan implicit layer manifests
+				"glvnd/egl_vendor.d/10_nvidia.json", // EGL vendor ICD manifest (GLVND)
+				"egl/egl_external_platform.d",       // EGL external platform manifests (GBM/Wayland)
+				// OptiX/NGX (libnvoptix.so, libnvidia-ngx.so) have no
+				// equivalent discoverable JSON manifest under configRoot;
+				// the application loading them locates them directly, so
+				// mounting the libraries above is enough.
 			},
 		},
+		CompatIfNewer,
 	},
 }
 
@@ -211,61 +250,72 @@ func (v *Volume) CreateAt(path string, s FileCloneStrategy) error {
 	return v.Create(s)
 }
 
+// Create builds the volume's tree and, for a versioned volume, atomically
+// swaps it into place: the tree is assembled in a staging directory and only
+// moved to its final <Path>/<Version> location once complete, so a
+// concurrently-running container never sees a half-populated version. Files
+// are content-hashed and deduplicated against previously-installed versions
+// via hardlinks, so keeping several driver versions around doesn't cost
+// N times the disk.
 func (v *Volume) Create(s FileCloneStrategy) (err error) {
 	if err = os.MkdirAll(v.Path, 0755); err != nil {
 		return
 	}
+
+	if v.Version == "" {
+		defer func() {
+			if err != nil {
+				v.Remove()
+			}
+		}()
+		return v.buildTree(v.Path, s)
+	}
+
+	staging, err := ioutil.TempDir(v.Path, ".staging-")
+	if err != nil {
+		return err
+	}
 	defer func() {
 		if err != nil {
-			v.Remove()
+			os.RemoveAll(staging)
 		}
 	}()
 
+	if err := v.buildTree(staging, s); err != nil {
+		return err
+	}
+	if err := v.dedupe(staging); err != nil {
+		return err
+	}
+
+	final := path.Join(v.Path, v.Version)
+	if err := os.RemoveAll(final); err != nil {
+		return err
+	}
+	return os.Rename(staging, final)
+}
+
+// buildTree populates root with every directory/file in v.dirs plus, if
+// configured, the CUDA forward-compatibility layer.
+func (v *Volume) buildTree(root string, s FileCloneStrategy) error {
+	var jobs []createJob
 	for _, d := range v.dirs {
-		dir := path.Join(v.Path, v.Version, d.name)
+		dir := path.Join(root, d.name)
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
+		mountDir := path.Join(v.Mountpoint, d.name)
 		for _, f := range d.files {
-			obj, err := elf.Open(f)
-			if err != nil {
-				return err
-			}
-			defer obj.Close()
-
-			ok, err := blacklisted(f, obj)
-			if err != nil {
-				return err
-			}
-			if ok {
-				continue
-			}
+			jobs = append(jobs, createJob{dir: dir, mountDir: mountDir, file: f, raw: d.raw})
+		}
+	}
+	if err := createFiles(jobs, s); err != nil {
+		return err
+	}
 
-			l := path.Join(dir, path.Base(f))
-			if err := s.Clone(f, l); err != nil {
-				return err
-			}
-			soname, err := obj.DynString(elf.DT_SONAME)
-			if err != nil {
-				return err
-			}
-			if len(soname) > 0 {
-				f = path.Join(v.Mountpoint, d.name, path.Base(f))
-				l = path.Join(dir, soname[0])
-				if err := os.Symlink(f, l); err != nil &&
-					!os.IsExist(err.(*os.LinkError).Err) {
-					return err
-				}
-				// XXX GLVND requires this symlink for indirect GLX support
-				// It won't be needed once we have an indirect GLX vendor neutral library.
-				if strings.HasPrefix(soname[0], "libGLX_nvidia") {
-					l = strings.Replace(l, "GLX_nvidia", "GLX_indirect", 1)
-					if err := os.Symlink(f, l); err != nil &&
-						!os.IsExist(err.(*os.LinkError).Err) {
-						return err
-					}
-				}
-			}
+	if v.compat != nil {
+		if err := v.createCompatAt(root, s); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -337,6 +387,8 @@ func LookupVolumes(prefix string) (vols VolumeMap, err error) {
 			Version:    drv,
 		}
 
+		var configs []string
+
 		for t, c := range vol.Components {
 			switch t {
 			case "binaries":
@@ -344,15 +396,42 @@ func LookupVolumes(prefix string) (vols VolumeMap, err error) {
 				if err != nil {
 					return nil, err
 				}
-				vol.dirs = append(vol.dirs, volumeDir{binDir, bins})
+				vol.dirs = append(vol.dirs, volumeDir{name: binDir, files: bins})
 			case "libraries":
 				libs32, libs64 := cache.Lookup(c...)
 				vol.dirs = append(vol.dirs,
-					volumeDir{lib32Dir, libs32},
-					volumeDir{lib64Dir, libs64},
+					volumeDir{name: lib32Dir, files: libs32},
+					volumeDir{name: lib64Dir, files: libs64},
 				)
+			case "configs":
+				groups, err := lookupConfigs(c...)
+				if err != nil {
+					return nil, err
+				}
+				for _, g := range groups {
+					vol.dirs = append(vol.dirs, volumeDir{name: path.Join(configDir, g.subdir), files: g.files, raw: true})
+					configs = append(configs, g.files...)
+				}
 			}
 		}
+
+		// The JSON ICD manifests reference libraries by SONAME only; make sure
+		// every library they point to actually ends up in the volume even if it
+		// wasn't listed (or was missed) under the "libraries" component.
+		if len(configs) > 0 {
+			if err := vol.resolveConfigLibraries(cache.Lookup, configs); err != nil {
+				return nil, err
+			}
+		}
+
+		if vol.Compat != CompatDisabled {
+			compat, err := discoverCompatLibrary(vol.Compat, drv)
+			if err != nil {
+				return nil, err
+			}
+			vol.compat = compat
+		}
+
 		vols[vol.Name] = vol
 	}
 	return
@@ -0,0 +1,146 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+package nvidia
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// writeFakeSharedObject writes a minimal but valid ELF64 shared object to p,
+// with a dynamic section declaring the given SONAME. It's just enough for
+// debug/elf.Open and File.DynString to work, so it stands in for a real
+// driver library in BenchmarkVolumeCreate without depending on anything
+// actually installed on the machine running the test.
+func writeFakeSharedObject(p, soname string) error {
+	const ehsize = 64
+
+	dynstr := append([]byte{0}, append([]byte(soname), 0)...)
+	const sonameOff = 1
+
+	var dyn bytes.Buffer
+	binary.Write(&dyn, binary.LittleEndian, int64(elf.DT_SONAME))
+	binary.Write(&dyn, binary.LittleEndian, uint64(sonameOff))
+	binary.Write(&dyn, binary.LittleEndian, int64(elf.DT_NULL))
+	binary.Write(&dyn, binary.LittleEndian, uint64(0))
+
+	shstrtab := []byte("\x00.dynamic\x00.dynstr\x00.shstrtab\x00")
+	const (
+		nameDynamic  uint32 = 1
+		nameDynstr   uint32 = nameDynamic + uint32(len(".dynamic\x00"))
+		nameShstrtab uint32 = nameDynstr + uint32(len(".dynstr\x00"))
+	)
+
+	dynstrOff := uint64(ehsize)
+	dynOff := alignUp(dynstrOff+uint64(len(dynstr)), 8)
+	shstrOff := alignUp(dynOff+uint64(dyn.Len()), 8)
+	shoff := alignUp(shstrOff+uint64(len(shstrtab)), 8)
+
+	var buf bytes.Buffer
+
+	// e_ident: magic, ELFCLASS64, ELFDATA2LSB, EV_CURRENT, ELFOSABI_NONE
+	buf.Write([]byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, uint16(elf.ET_DYN))
+	binary.Write(&buf, binary.LittleEndian, uint16(elf.EM_X86_64))
+	binary.Write(&buf, binary.LittleEndian, uint32(elf.EV_CURRENT))
+	binary.Write(&buf, binary.LittleEndian, uint64(0))  // e_entry
+	binary.Write(&buf, binary.LittleEndian, uint64(0))  // e_phoff
+	binary.Write(&buf, binary.LittleEndian, shoff)      // e_shoff
+	binary.Write(&buf, binary.LittleEndian, uint32(0))  // e_flags
+	binary.Write(&buf, binary.LittleEndian, uint16(ehsize))
+	binary.Write(&buf, binary.LittleEndian, uint16(0))  // e_phentsize
+	binary.Write(&buf, binary.LittleEndian, uint16(0))  // e_phnum
+	binary.Write(&buf, binary.LittleEndian, uint16(64)) // e_shentsize
+	binary.Write(&buf, binary.LittleEndian, uint16(4))  // e_shnum
+	binary.Write(&buf, binary.LittleEndian, uint16(3))  // e_shstrndx
+
+	padTo(&buf, dynstrOff)
+	buf.Write(dynstr)
+	padTo(&buf, dynOff)
+	buf.Write(dyn.Bytes())
+	padTo(&buf, shstrOff)
+	buf.Write(shstrtab)
+	padTo(&buf, shoff)
+
+	writeShdr(&buf, 0, 0, 0, 0, 0, 0, 0, 0) // SHT_NULL
+	writeShdr(&buf, nameDynamic, uint32(elf.SHT_DYNAMIC), dynOff, uint64(dyn.Len()), 2, 8, 16, uint64(elf.SHF_ALLOC))
+	writeShdr(&buf, nameDynstr, uint32(elf.SHT_STRTAB), dynstrOff, uint64(len(dynstr)), 0, 1, 0, 0)
+	writeShdr(&buf, nameShstrtab, uint32(elf.SHT_STRTAB), shstrOff, uint64(len(shstrtab)), 0, 1, 0, 0)
+
+	return ioutil.WriteFile(p, buf.Bytes(), 0644)
+}
+
+func alignUp(v uint64, align uint64) uint64 {
+	return (v + align - 1) &^ (align - 1)
+}
+
+func padTo(buf *bytes.Buffer, target uint64) {
+	for uint64(buf.Len()) < target {
+		buf.WriteByte(0)
+	}
+}
+
+// writeShdr appends one Elf64_Shdr. link and addralign/entsize are given in
+// the order most callers above actually vary them in.
+func writeShdr(buf *bytes.Buffer, name, typ uint32, off, size uint64, link, addralign, entsize uint32, flags uint64) {
+	binary.Write(buf, binary.LittleEndian, name)
+	binary.Write(buf, binary.LittleEndian, typ)
+	binary.Write(buf, binary.LittleEndian, flags)
+	binary.Write(buf, binary.LittleEndian, uint64(0)) // addr
+	binary.Write(buf, binary.LittleEndian, off)
+	binary.Write(buf, binary.LittleEndian, size)
+	binary.Write(buf, binary.LittleEndian, link)
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // info
+	binary.Write(buf, binary.LittleEndian, uint64(addralign))
+	binary.Write(buf, binary.LittleEndian, uint64(entsize))
+}
+
+// BenchmarkVolumeCreate exercises Volume.Create's parallel per-file ELF
+// inspection and cloning (parallel.go) against a tree of fake shared
+// objects, so regressions in the worker pool show up without needing a real
+// driver install.
+func BenchmarkVolumeCreate(b *testing.B) {
+	const numLibs = 64
+
+	srcDir, err := ioutil.TempDir("", "nvidia-fake-libs-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	files := make([]string, numLibs)
+	for i := 0; i < numLibs; i++ {
+		name := fmt.Sprintf("libfake%d.so.1", i)
+		p := path.Join(srcDir, name)
+		if err := writeFakeSharedObject(p, name); err != nil {
+			b.Fatal(err)
+		}
+		files[i] = p
+	}
+
+	info := &VolumeInfo{Name: "fake", Mountpoint: "/usr/local/fake"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dstDir, err := ioutil.TempDir("", "nvidia-fake-vol-")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		vol := &Volume{
+			VolumeInfo: info,
+			dirs:       []volumeDir{{name: lib64Dir, files: files}},
+		}
+		if err := vol.CreateAt(dstDir, LinkOrCopyStrategy{}); err != nil {
+			b.Fatal(err)
+		}
+
+		os.RemoveAll(dstDir)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2015-2016, NVIDIA CORPORATION. All rights reserved.
+
+// Package nvml is a minimal cgo binding to libnvidia-ml.so, the NVIDIA
+// Management Library shipped by the display driver. It only wraps the
+// handful of calls this module actually needs.
+package nvml
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+typedef int (*nvmlInit_t)(void);
+typedef int (*nvmlShutdown_t)(void);
+typedef int (*nvmlSystemGetDriverVersion_t)(char *, unsigned int);
+
+static void *nvml_handle;
+static nvmlInit_t nvmlInit_f;
+static nvmlShutdown_t nvmlShutdown_f;
+static nvmlSystemGetDriverVersion_t nvmlSystemGetDriverVersion_f;
+
+static int nvml_dlopen(void) {
+	if (nvml_handle != NULL) {
+		return 0;
+	}
+	nvml_handle = dlopen("libnvidia-ml.so.1", RTLD_LAZY);
+	if (nvml_handle == NULL) {
+		nvml_handle = dlopen("libnvidia-ml.so", RTLD_LAZY);
+	}
+	if (nvml_handle == NULL) {
+		return -1;
+	}
+	nvmlInit_f = (nvmlInit_t)dlsym(nvml_handle, "nvmlInit_v2");
+	nvmlShutdown_f = (nvmlShutdown_t)dlsym(nvml_handle, "nvmlShutdown");
+	nvmlSystemGetDriverVersion_f = (nvmlSystemGetDriverVersion_t)dlsym(nvml_handle, "nvmlSystemGetDriverVersion");
+	if (nvmlInit_f == NULL || nvmlShutdown_f == NULL || nvmlSystemGetDriverVersion_f == NULL) {
+		return -1;
+	}
+	return 0;
+}
+
+// cgo can't call a C function-pointer variable directly, so these thin
+// wrappers are what the Go side actually calls.
+static int nvml_init(void) {
+	return nvmlInit_f();
+}
+
+static int nvml_shutdown(void) {
+	return nvmlShutdown_f();
+}
+
+static int nvml_system_get_driver_version(char *buf, unsigned int length) {
+	return nvmlSystemGetDriverVersion_f(buf, length);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+)
+
+const maxVersionLength = 80
+
+// DriverVersion returns the driver version string reported by
+// nvmlSystemGetDriverVersion, dlopen'ing libnvidia-ml.so on demand.
+func DriverVersion() (string, error) {
+	if C.nvml_dlopen() != 0 {
+		return "", fmt.Errorf("nvml: could not load libnvidia-ml.so")
+	}
+	if rc := C.nvml_init(); rc != 0 {
+		return "", fmt.Errorf("nvml: nvmlInit failed (%d)", int(rc))
+	}
+	defer C.nvml_shutdown()
+
+	buf := make([]C.char, maxVersionLength)
+	if rc := C.nvml_system_get_driver_version(&buf[0], C.uint(maxVersionLength)); rc != 0 {
+		return "", fmt.Errorf("nvml: nvmlSystemGetDriverVersion failed (%d)", int(rc))
+	}
+	return C.GoString(&buf[0]), nil
+}